@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBackendHasReadWriteDelete(t *testing.T) {
+	b := NewMemoryBackend()
+	key := PathKey{PathName: "ab/cd", Filename: "abcdef"}
+
+	if b.Has("peer1", key) {
+		t.Fatalf("Has should be false before any write")
+	}
+
+	if _, err := b.Write("peer1", key, strings.NewReader("payload")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !b.Has("peer1", key) {
+		t.Fatalf("Has should be true after Write")
+	}
+
+	size, r, err := b.Read("peer1", key)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if int64(len(data)) != size || string(data) != "payload" {
+		t.Errorf("Read returned %q (size %d), want %q (size %d)", data, size, "payload", len("payload"))
+	}
+
+	if err := b.Delete("peer1", key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if b.Has("peer1", key) {
+		t.Errorf("Has should be false after Delete")
+	}
+}
+
+func TestBackendFromDSNDispatch(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := backendFromDSN("mem://", dir); err != nil {
+		t.Errorf("mem:// DSN should not error: %v", err)
+	}
+
+	fileDSN := "file://" + filepath.ToSlash(dir)
+	backend, err := backendFromDSN(fileDSN, dir)
+	if err != nil {
+		t.Fatalf("file:// DSN should not error: %v", err)
+	}
+	if _, ok := backend.(*FSBackend); !ok {
+		t.Errorf("file:// DSN should build an *FSBackend, got %T", backend)
+	}
+
+	if _, err := backendFromDSN("file://justadir", dir); err == nil {
+		t.Errorf("file://host-form DSN (no leading slash after the host) should error, not silently root at \"\"")
+	}
+
+	if _, err := backendFromDSN("s3://bucket/prefix", dir); err == nil {
+		t.Errorf("s3:// DSN should error since S3Backend isn't wired to a client")
+	}
+
+	backend, err = backendFromDSN("plain-directory-name", dir)
+	if err != nil {
+		t.Fatalf("schemeless DSN should fall back to the file backend, not error: %v", err)
+	}
+	if _, ok := backend.(*FSBackend); !ok {
+		t.Errorf("schemeless DSN should build an *FSBackend, got %T", backend)
+	}
+}
+
+func TestNewStorePropagatesBackendFromDSNError(t *testing.T) {
+	if _, err := NewStore(StoreOpts{Root: "s3://bucket/prefix"}); err == nil {
+		t.Errorf("NewStore should propagate the s3:// DSN error instead of falling back to disk")
+	}
+}
+
+func TestFSBackendHasReadWriteDelete(t *testing.T) {
+	b := NewFSBackend(t.TempDir())
+	key := PathKey{PathName: "ab/cd", Filename: "abcdef"}
+
+	if _, err := b.Write("peer1", key, bytes.NewReader([]byte("payload"))); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !b.Has("peer1", key) {
+		t.Fatalf("Has should be true after Write")
+	}
+
+	if _, err := os.Stat(b.fullPath("peer1", key)); err != nil {
+		t.Errorf("blob should exist on disk at the expected path: %v", err)
+	}
+
+	if err := b.Delete("peer1", key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if b.Has("peer1", key) {
+		t.Errorf("Has should be false after Delete")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storeFS is an io/fs.FS view over a single peer's logical key namespace.
+// Keys become paths independent of the CAS sharding underneath, so callers
+// can use fs.WalkDir, fs.Glob, and http.FS without ever seeing the sha1
+// shard layout.
+type storeFS struct {
+	store *Store
+	id    string
+	base  string // "." for the namespace root, otherwise a cleaned sub-path
+}
+
+// FS returns an fs.FS rooted at id's logical key namespace. The namespace
+// comes from the in-memory keyRegistry (see merkle.go), which only knows
+// about keys written in this process: a blob stored before a restart, or by
+// another Store, won't appear under ReadDir/Glob/Open until it's written
+// (or deduped via WriteAtomic) again.
+func (s *Store) FS(id string) fs.FS {
+	return &storeFS{store: s, id: id, base: "."}
+}
+
+var (
+	_ fs.FS        = (*storeFS)(nil)
+	_ fs.ReadDirFS = (*storeFS)(nil)
+	_ fs.StatFS    = (*storeFS)(nil)
+	_ fs.GlobFS    = (*storeFS)(nil)
+	_ fs.SubFS     = (*storeFS)(nil)
+)
+
+func (sf *storeFS) join(name string) string {
+	if sf.base == "." {
+		return name
+	}
+	return path.Join(sf.base, name)
+}
+
+// entries reports the names and kinds of a directory's immediate children,
+// derived from every registered key under that prefix.
+func (sf *storeFS) entries(dir string) (map[string]bool, bool) {
+	prefix := ""
+	if dir != "." {
+		prefix = dir + "/"
+	}
+
+	children := map[string]bool{}
+	found := false
+	for _, key := range sf.store.keys.list(sf.id) {
+		if prefix != "" && !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if rest == "" {
+			continue
+		}
+		found = true
+		name := rest
+		isDir := false
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = rest[:i]
+			isDir = true
+		}
+		children[name] = children[name] || isDir
+	}
+	return children, found
+}
+
+func (sf *storeFS) isLeaf(name string) bool {
+	return sf.store.Has(sf.id, name)
+}
+
+func (sf *storeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	full := sf.join(name)
+
+	if sf.isLeaf(full) {
+		size, r, err := sf.store.Read(sf.id, full)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &storeFile{info: fileInfo{name: path.Base(full), size: size}, r: r, closer: r}, nil
+	}
+
+	children, found := sf.entries(full)
+	if !found && full != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &storeDir{info: fileInfo{name: path.Base(full), isDir: true}, entries: dirEntries(children)}, nil
+}
+
+func (sf *storeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	children, found := sf.entries(sf.join(name))
+	if !found && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return dirEntries(children), nil
+}
+
+func (sf *storeFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	full := sf.join(name)
+	if sf.isLeaf(full) {
+		size, r, err := sf.store.Read(sf.id, full)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		r.Close()
+		return fileInfo{name: path.Base(full), size: size}, nil
+	}
+	if _, found := sf.entries(full); found || full == "." {
+		return fileInfo{name: path.Base(full), isDir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Glob matches pattern (doublestar-style, "**" spans directories) against
+// every key registered under this view, returning matches in lexical order.
+func (sf *storeFS) Glob(pattern string) ([]string, error) {
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	prefix := ""
+	if sf.base != "." {
+		prefix = sf.base + "/"
+	}
+	for _, key := range sf.store.keys.list(sf.id) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(key, prefix)
+		if re.MatchString(rel) {
+			matches = append(matches, rel)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (sf *storeFS) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	return &storeFS{store: sf.store, id: sf.id, base: sf.join(dir)}, nil
+}
+
+func dirEntries(children map[string]bool) []fs.DirEntry {
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		out = append(out, fs.FileInfoToDirEntry(fileInfo{name: name, isDir: children[name]}))
+	}
+	return out
+}
+
+// fileInfo is a minimal fs.FileInfo; the CAS tracks no mtime or mode beyond
+// "file" or "directory", so both are synthesized.
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi fileInfo) Name() string { return fi.name }
+func (fi fileInfo) Size() int64  { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.isDir }
+func (fi fileInfo) Sys() any           { return nil }
+
+// storeFile adapts a Store.Read stream to fs.File.
+type storeFile struct {
+	info   fileInfo
+	r      io.Reader
+	closer io.Closer
+}
+
+func (f *storeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *storeFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *storeFile) Close() error {
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+// storeDir implements fs.ReadDirFile for a virtual directory.
+type storeDir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *storeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *storeDir) Close() error               { return nil }
+func (d *storeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *storeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := len(d.entries) - d.offset
+	if n <= 0 {
+		out := d.entries[d.offset:]
+		d.offset = len(d.entries)
+		return out, nil
+	}
+	if remaining == 0 {
+		return nil, io.EOF
+	}
+	if n > remaining {
+		n = remaining
+	}
+	out := d.entries[d.offset : d.offset+n]
+	d.offset += n
+	return out, nil
+}
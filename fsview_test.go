@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestStoreFSReadDirAndGlob(t *testing.T) {
+	s := newTestStore(t, NewMemoryBackend())
+	const id = "peer1"
+
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/sub/c.log"} {
+		if _, err := s.Write(id, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Write %s: %v", key, err)
+		}
+	}
+
+	sfs := s.FS(id)
+
+	entries, err := fs.ReadDir(sfs, ".")
+	if err != nil {
+		t.Fatalf("ReadDir(.): %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "dir" {
+		t.Errorf("ReadDir(.) entries = %v, want [a.txt dir]", names)
+	}
+
+	data, err := fs.ReadFile(sfs, "dir/b.txt")
+	if err != nil {
+		t.Fatalf("ReadFile(dir/b.txt): %v", err)
+	}
+	if string(data) != "dir/b.txt" {
+		t.Errorf("ReadFile(dir/b.txt) = %q, want %q", data, "dir/b.txt")
+	}
+
+	matches, err := fs.Glob(sfs, "**/*.log")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) != 1 || matches[0] != "dir/sub/c.log" {
+		t.Errorf("Glob(**/*.log) = %v, want [dir/sub/c.log]", matches)
+	}
+
+	sub, err := fs.Sub(sfs, "dir")
+	if err != nil {
+		t.Fatalf("Sub(dir): %v", err)
+	}
+	subEntries, err := fs.ReadDir(sub, ".")
+	if err != nil {
+		t.Fatalf("ReadDir on sub-FS: %v", err)
+	}
+	if len(subEntries) != 2 || subEntries[0].Name() != "b.txt" || subEntries[1].Name() != "sub" {
+		t.Errorf("sub-FS ReadDir(.) entries = %v, want [b.txt sub]", subEntries)
+	}
+
+	if _, err := fs.Stat(sfs, "does/not/exist"); err == nil {
+		t.Errorf("Stat on a missing path should error")
+	}
+}
+
+func TestStoreFSOpenReturnsReadableFile(t *testing.T) {
+	s := newTestStore(t, NewMemoryBackend())
+	const id = "peer1"
+	if _, err := s.Write(id, "a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	f, err := s.FS(id).Open("a.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("content = %q, want %q", data, "hello")
+	}
+}
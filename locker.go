@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// locker is a keyed lock manager: each key gets its own reference-counted
+// RWMutex, so operations on different keys never block each other while
+// operations on the same key serialize correctly. Mirrors buildkit's
+// cacheManager locker.
+type locker struct {
+	mu    sync.Mutex
+	locks map[string]*refCountedLock
+}
+
+type refCountedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func newLocker() *locker {
+	return &locker{locks: make(map[string]*refCountedLock)}
+}
+
+func (l *locker) get(key string) *refCountedLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rl, ok := l.locks[key]
+	if !ok {
+		rl = &refCountedLock{}
+		l.locks[key] = rl
+	}
+	rl.refs++
+	return rl
+}
+
+func (l *locker) release(key string, rl *refCountedLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rl.refs--
+	if rl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// Lock takes an exclusive lock on key and returns the func that releases it.
+func (l *locker) Lock(key string) func() {
+	rl := l.get(key)
+	rl.Lock()
+	return func() {
+		rl.Unlock()
+		l.release(key, rl)
+	}
+}
+
+// RLock takes a shared lock on key and returns the func that releases it.
+func (l *locker) RLock(key string) func() {
+	rl := l.get(key)
+	rl.RLock()
+	return func() {
+		rl.RUnlock()
+		l.release(key, rl)
+	}
+}
+
+// unlockingReadCloser defers releasing a locker lock until the wrapped
+// stream is closed, so a Read holds its lock for as long as the caller is
+// actually reading rather than just for the initial open.
+type unlockingReadCloser struct {
+	io.ReadCloser
+	unlock func()
+	once   sync.Once
+}
+
+func (u *unlockingReadCloser) Close() error {
+	err := u.ReadCloser.Close()
+	u.once.Do(u.unlock)
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingBackend wraps a MemoryBackend but holds inside Write until release
+// is closed, so a test can observe whether a second concurrent Write for the
+// same key is allowed to start before the first one finishes.
+type blockingBackend struct {
+	*MemoryBackend
+	release <-chan struct{}
+	active  int32
+	maxSeen int32
+}
+
+func (b *blockingBackend) Write(id string, key PathKey, r io.Reader) (int64, error) {
+	n := atomic.AddInt32(&b.active, 1)
+	for {
+		old := atomic.LoadInt32(&b.maxSeen)
+		if n <= old || atomic.CompareAndSwapInt32(&b.maxSeen, old, n) {
+			break
+		}
+	}
+	<-b.release
+	defer atomic.AddInt32(&b.active, -1)
+	return b.MemoryBackend.Write(id, key, r)
+}
+
+func TestStoreSerializesConcurrentWritesToSameKey(t *testing.T) {
+	release := make(chan struct{})
+	backend := &blockingBackend{MemoryBackend: NewMemoryBackend(), release: release}
+	s := newTestStore(t, backend)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			s.Write("peer1", "same.txt", strings.NewReader("x"))
+		}()
+	}
+
+	// Give both goroutines a chance to reach backend.Write if the locker
+	// failed to serialize them.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&backend.maxSeen); max > 1 {
+		t.Errorf("locker should serialize writes to the same key, but saw %d concurrent backend.Write calls", max)
+	}
+}
+
+func TestStoreAllowsConcurrentWritesToDifferentKeys(t *testing.T) {
+	release := make(chan struct{})
+	backend := &blockingBackend{MemoryBackend: NewMemoryBackend(), release: release}
+	s := newTestStore(t, backend)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.Write("peer1", "a.txt", strings.NewReader("x"))
+	}()
+	go func() {
+		defer wg.Done()
+		s.Write("peer1", "b.txt", strings.NewReader("x"))
+	}()
+
+	deadline := time.After(time.Second)
+	for {
+		if atomic.LoadInt32(&backend.active) == 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("writes to different keys should be able to run concurrently")
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+// countingBackend counts Stat calls so a test can tell whether Has served a
+// hot key from sizeCache instead of going back to the backend.
+type countingBackend struct {
+	*MemoryBackend
+	statCalls int32
+}
+
+// Stat counts calls and fakes success: MemoryBackend.Stat always errors
+// (it expects callers to use Has instead), which would prevent Store.Has
+// from ever populating sizeCache in this test.
+func (b *countingBackend) Stat(id string, key PathKey) (os.FileInfo, error) {
+	atomic.AddInt32(&b.statCalls, 1)
+	return fileInfo{name: key.Filename}, nil
+}
+
+func TestHasServesHotKeyFromSizeCache(t *testing.T) {
+	backend := &countingBackend{MemoryBackend: NewMemoryBackend()}
+	s := newTestStore(t, backend)
+
+	// Write the blob straight to the backend, bypassing Store.Write, so
+	// Store's sizeCache starts out empty and the first Has has to fall back
+	// to a real backend.Stat call.
+	pathKey := s.PathTransformFunc("a.txt")
+	if _, err := backend.Write("peer1", pathKey, strings.NewReader("hello")); err != nil {
+		t.Fatalf("backend.Write: %v", err)
+	}
+
+	if !s.Has("peer1", "a.txt") {
+		t.Fatalf("Has should report true for a blob present on the backend")
+	}
+	afterFirst := atomic.LoadInt32(&backend.statCalls)
+	if afterFirst != 1 {
+		t.Fatalf("first Has on a cold key should call backend.Stat exactly once, got %d", afterFirst)
+	}
+
+	if !s.Has("peer1", "a.txt") {
+		t.Fatalf("Has should report true for a cached key")
+	}
+	afterSecond := atomic.LoadInt32(&backend.statCalls)
+
+	if afterSecond != afterFirst {
+		t.Errorf("Has on a hot key should be served from sizeCache without calling backend.Stat again (first=%d, second=%d)", afterFirst, afterSecond)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Digest is a recursive sha256 content digest, formatted "sha256:<hex>".
+type Digest string
+
+func digestFromSum(sum [sha256.Size]byte) Digest {
+	return Digest("sha256:" + hex.EncodeToString(sum[:]))
+}
+
+const (
+	modeFile = "file"
+	modeDir  = "dir"
+)
+
+// keyRegistry tracks the logical keys written per peer id, since the CAS
+// sharded layout on disk throws away the original key structure. Checksum
+// and ChecksumWildcard walk this registry to reconstruct the virtual
+// directory tree a key like "a/b/c.txt" implies.
+//
+// The registry only lives in memory for this process: a sha1 shard path
+// cannot be reversed back into its logical key, so FSBackend.Walk has no
+// way to repopulate it, and a restarted process starts with an empty
+// registry even though the blobs are still on disk. Write/WriteAtomic
+// register every key they see, so the registry converges again as keys are
+// re-written or re-deduped (see Store.WriteAtomic), but a blob that is
+// never written again in this process stays invisible to Checksum,
+// ChecksumWildcard, and FS until then.
+type keyRegistry struct {
+	mu   sync.RWMutex
+	keys map[string]map[string]struct{} // id -> logical key -> struct{}
+}
+
+func newKeyRegistry() *keyRegistry {
+	return &keyRegistry{keys: make(map[string]map[string]struct{})}
+}
+
+func (r *keyRegistry) add(id, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys[id] == nil {
+		r.keys[id] = make(map[string]struct{})
+	}
+	r.keys[id][key] = struct{}{}
+}
+
+func (r *keyRegistry) remove(id, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys[id], key)
+}
+
+func (r *keyRegistry) list(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.keys[id]))
+	for k := range r.keys[id] {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// checksumCache caches recursive digests by cleaned absolute path, with two
+// entries per directory: "/dir/" for its own header digest and "/dir" for
+// the digest of its recursive contents, matching the layout buildkit's
+// contenthash package uses so the header can change (e.g. a rename) without
+// invalidating everything beneath it.
+type checksumCache struct {
+	mu      sync.RWMutex
+	entries map[string]Digest
+}
+
+func newChecksumCache() *checksumCache {
+	return &checksumCache{entries: make(map[string]Digest)}
+}
+
+func (c *checksumCache) get(p string) (Digest, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	d, ok := c.entries[p]
+	return d, ok
+}
+
+func (c *checksumCache) set(p string, d Digest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[p] = d
+}
+
+// invalidate drops the cached digests for p and every ancestor directory of
+// p, since a write or delete below any of them changes their recursive
+// content digest.
+func (c *checksumCache) invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cur := p; ; cur = path.Dir(cur) {
+		delete(c.entries, cur)
+		delete(c.entries, cur+"/")
+		if cur == "/" {
+			break
+		}
+	}
+}
+
+// Checksum computes a stable recursive digest for key. If key names a
+// stored blob it is the sha256 of the blob's content; if key names a
+// virtual directory (a prefix of other stored keys) it is the sha256 of
+// its sorted "name\0mode\0digest" child records, so the same tree produces
+// the same digest on every peer regardless of write order.
+func (s *Store) Checksum(id, key string) (Digest, error) {
+	cleaned := path.Clean("/" + key)
+	if d, ok := s.checksums.get(cleaned); ok {
+		return d, nil
+	}
+	d, err := s.computeChecksum(id, cleaned)
+	if err != nil {
+		return "", err
+	}
+	s.checksums.set(cleaned, d)
+	return d, nil
+}
+
+func (s *Store) computeChecksum(id, cleaned string) (Digest, error) {
+	logicalKey := strings.TrimPrefix(cleaned, "/")
+	if s.Has(id, logicalKey) {
+		return s.leafChecksum(id, logicalKey)
+	}
+	return s.dirChecksum(id, cleaned)
+}
+
+func (s *Store) leafChecksum(id, key string) (Digest, error) {
+	_, r, err := s.Read(id, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return digestFromSum(sum), nil
+}
+
+func (s *Store) dirChecksum(id, cleaned string) (Digest, error) {
+	prefix := strings.TrimPrefix(cleaned, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	children := map[string]bool{} // child logical path -> isDir
+	for _, key := range s.keys.list(id) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if rest == "" {
+			continue
+		}
+		name := rest
+		isDir := false
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = rest[:i]
+			isDir = true
+		}
+		children[name] = children[name] || isDir
+	}
+	if len(children) == 0 {
+		return "", fmt.Errorf("checksum: no such key or directory: %s", cleaned)
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The header digest identifies the directory itself (name and mode),
+	// independent of what's inside it, and is cached at "/dir/". It is
+	// only computed here, as part of a full walk, and no Store method
+	// returns it: Checksum cleans a trailing "/" away and always returns
+	// the content digest.
+	hh := sha256.New()
+	fmt.Fprintf(hh, "%s\x00%s\x00", path.Base(cleaned), modeDir)
+	var hsum [sha256.Size]byte
+	copy(hsum[:], hh.Sum(nil))
+	s.checksums.set(cleaned+"/", digestFromSum(hsum))
+
+	// The content digest aggregates the sorted child records; this is what
+	// computeChecksum returns for a directory and what a parent's own
+	// content digest is built from. Checksum caches it at "/dir" (no
+	// trailing slash) for the directory it was called on; the content
+	// digests of subdirectories computed on the way are not cached.
+	h := sha256.New()
+	for _, name := range names {
+		mode := modeFile
+		childCleaned := path.Join(cleaned, name)
+		if children[name] {
+			mode = modeDir
+		}
+		childDigest, err := s.computeChecksum(id, childCleaned)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", name, mode, childDigest)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return digestFromSum(sum), nil
+}
+
+// ChecksumWildcard matches pattern (doublestar-style, "**" spans any number
+// of path segments) against every key stored for id, and returns a digest
+// over the matched leaves' digests taken in lexical order, so two peers
+// holding the same matched set always agree regardless of write order.
+func (s *Store) ChecksumWildcard(id, pattern string) (Digest, error) {
+	re, err := globToRegexp(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	matched := make([]string, 0)
+	for _, key := range s.keys.list(id) {
+		if re.MatchString(key) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Strings(matched)
+	if len(matched) == 0 {
+		return "", fmt.Errorf("checksum: pattern %q matched no keys", pattern)
+	}
+
+	h := sha256.New()
+	for _, key := range matched {
+		d, err := s.leafChecksum(id, key)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", key, d)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return digestFromSum(sum), nil
+}
+
+// globToRegexp translates a doublestar glob ("**" matches across segments,
+// "*" and "?" stay within one) into an anchored regexp. Patterns are split
+// on "/" and translated segment by segment so a "**" segment can match zero
+// path segments (e.g. "**/*.log" matches the top-level "foo.log", not just
+// "a/foo.log"), the same behavior doublestar itself gives that pattern.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	segs := strings.Split(pattern, "/")
+	var sb strings.Builder
+	sb.WriteString("^")
+	first := true
+	prevWasDoubleStar := false
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		if seg == "**" {
+			switch {
+			case last && first:
+				// A bare "**" pattern matches anything, including a
+				// leaf with no separators at all.
+				sb.WriteString(".*")
+			case last:
+				// A trailing "/**" matches the preceding segment itself
+				// as well as anything beneath it.
+				sb.WriteString("(?:/.*)?")
+			default:
+				if !first {
+					sb.WriteString("/")
+				}
+				sb.WriteString("(?:[^/]+/)*")
+			}
+			prevWasDoubleStar = true
+			first = false
+			continue
+		}
+		if !first && !prevWasDoubleStar {
+			sb.WriteString("/")
+		}
+		sb.WriteString(translateSegment(seg))
+		prevWasDoubleStar = false
+		first = false
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// translateSegment translates a single "/"-free glob segment: "*" and "?"
+// stay within the segment, everything else is matched literally.
+func translateSegment(seg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(seg); i++ {
+		switch seg[i] {
+		case '*':
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(seg[i])))
+		}
+	}
+	return sb.String()
+}
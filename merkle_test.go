@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestGlobToRegexpLeadingDoubleStarMatchesZeroSegments(t *testing.T) {
+	re, err := globToRegexp("**/*.log")
+	if err != nil {
+		t.Fatalf("globToRegexp: %v", err)
+	}
+
+	for _, key := range []string{"foo.log", "a/foo.log", "a/b/foo.log"} {
+		if !re.MatchString(key) {
+			t.Errorf("pattern **/*.log should match %q", key)
+		}
+	}
+	if re.MatchString("foo.txt") {
+		t.Errorf("pattern **/*.log should not match foo.txt")
+	}
+}
+
+func TestGlobToRegexpMidDoubleStarMatchesZeroSegments(t *testing.T) {
+	re, err := globToRegexp("a/**/b")
+	if err != nil {
+		t.Fatalf("globToRegexp: %v", err)
+	}
+
+	for _, key := range []string{"a/b", "a/x/b", "a/x/y/b"} {
+		if !re.MatchString(key) {
+			t.Errorf("pattern a/**/b should match %q", key)
+		}
+	}
+	if re.MatchString("a/c") {
+		t.Errorf("pattern a/**/b should not match a/c")
+	}
+}
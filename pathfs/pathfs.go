@@ -0,0 +1,95 @@
+// Package pathfs provides Windows-safe path handling for the content store.
+// The CAS shard layout (an 8x5-char sha1 prefix plus a 40-char filename,
+// nested under a peer id) can easily push a full path past Windows'
+// MAX_PATH of 260 characters, and components can collide with a reserved
+// device name like NUL or COM1. This package centralizes the \\?\ long-path
+// escape and reserved-name handling so Store never has to think about it,
+// analogous to the restic/fs shim restic routes all its file access through.
+package pathfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// reserved holds the Windows device names that cannot be used as a path
+// component, bare or with an extension (NUL and NUL.txt are both reserved).
+var reserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// IsReservedName reports whether name, a single path component with or
+// without an extension, is a Windows reserved device name. The match is
+// case-insensitive, matching Windows' own semantics.
+func IsReservedName(name string) bool {
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	return reserved[strings.ToUpper(base)]
+}
+
+// SanitizeComponent rewrites name if it collides with a reserved device
+// name, leaving every other component untouched.
+func SanitizeComponent(name string) string {
+	if IsReservedName(name) {
+		return "_" + name
+	}
+	return name
+}
+
+// fixPath prepends the \\?\ long-path prefix on Windows so paths built from
+// the CAS shard layout don't trip MAX_PATH. It is a no-op everywhere else.
+// The caller must pass (or be able to resolve) an absolute path, since the
+// \\?\ prefix disables the usual relative-path and "." / ".." handling.
+func fixPath(p string) string {
+	if runtime.GOOS != "windows" {
+		return p
+	}
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return `\\?\` + abs
+}
+
+func Open(name string) (*os.File, error) {
+	return os.Open(fixPath(name))
+}
+
+func Create(name string) (*os.File, error) {
+	return os.Create(fixPath(name))
+}
+
+func CreateTemp(dir, pattern string) (*os.File, error) {
+	return os.CreateTemp(fixPath(dir), pattern)
+}
+
+func Stat(name string) (os.FileInfo, error) {
+	return os.Stat(fixPath(name))
+}
+
+func MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(fixPath(path), perm)
+}
+
+func Remove(name string) error {
+	return os.Remove(fixPath(name))
+}
+
+func RemoveAll(path string) error {
+	return os.RemoveAll(fixPath(path))
+}
+
+func Rename(oldpath, newpath string) error {
+	return os.Rename(fixPath(oldpath), fixPath(newpath))
+}
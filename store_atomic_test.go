@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteAtomicDedupesExistingBlob(t *testing.T) {
+	s := newTestStore(t, NewMemoryBackend())
+	const id = "peer1"
+
+	n, deduped, err := s.WriteAtomic(id, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("WriteAtomic (first write): %v", err)
+	}
+	if deduped {
+		t.Errorf("first WriteAtomic of a.txt should not be deduped")
+	}
+	if n != 5 {
+		t.Errorf("written = %d, want 5", n)
+	}
+
+	n, deduped, err = s.WriteAtomic(id, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("WriteAtomic (second write): %v", err)
+	}
+	if !deduped {
+		t.Errorf("second WriteAtomic of a.txt should be deduped")
+	}
+	if n != 0 {
+		t.Errorf("deduped write should report 0 bytes written, got %d", n)
+	}
+}
+
+// TestWriteAtomicDedupInvalidatesChecksum is a regression test: a dedup via
+// WriteAtomic on a Store that only just learned about an already-registered
+// sibling key (e.g. after a restart, or via a different Store sharing the
+// same backend) must invalidate any ancestor digest it had already cached,
+// or the cached digest silently diverges from the tree's real content.
+func TestWriteAtomicDedupInvalidatesChecksum(t *testing.T) {
+	backend := NewMemoryBackend()
+	const id = "peer1"
+
+	s1 := newTestStore(t, backend)
+	if _, err := s1.Write(id, "dir/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("s1.Write a.txt: %v", err)
+	}
+	if _, err := s1.Write(id, "dir/b.txt", strings.NewReader("b")); err != nil {
+		t.Fatalf("s1.Write b.txt: %v", err)
+	}
+	want, err := s1.Checksum(id, "dir")
+	if err != nil {
+		t.Fatalf("s1.Checksum: %v", err)
+	}
+
+	// s2 shares the backend (simulating a second process against the same
+	// storage) but only knows about a.txt so far, and caches a digest built
+	// from that partial view.
+	s2 := newTestStore(t, backend)
+	if _, err := s2.Write(id, "dir/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("s2.Write a.txt: %v", err)
+	}
+	if _, err := s2.Checksum(id, "dir"); err != nil {
+		t.Fatalf("s2.Checksum (partial): %v", err)
+	}
+
+	// b.txt's blob is already on the shared backend (written by s1), so this
+	// dedup-registers it on s2 without rewriting it.
+	_, deduped, err := s2.WriteAtomic(id, "dir/b.txt", strings.NewReader("b"))
+	if err != nil {
+		t.Fatalf("s2.WriteAtomic b.txt: %v", err)
+	}
+	if !deduped {
+		t.Fatalf("b.txt should already be present on the shared backend")
+	}
+
+	got, err := s2.Checksum(id, "dir")
+	if err != nil {
+		t.Fatalf("s2.Checksum (after dedup): %v", err)
+	}
+	if got != want {
+		t.Errorf("s2.Checksum(dir) after dedup registering b.txt = %q, want %q (matching s1, which saw both files)", got, want)
+	}
+}
+
+func TestFSBackendWriteIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	b := NewFSBackend(dir)
+	key := PathKey{PathName: "ab", Filename: "abcdef"}
+
+	if _, err := b.Write("peer1", key, strings.NewReader("payload")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	full := b.fullPath("peer1", key)
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(data) != "payload" {
+		t.Errorf("content = %q, want %q", data, "payload")
+	}
+
+	// No .tmp-* sibling should be left behind once Write returns.
+	entries, err := os.ReadDir(filepath.Dir(full))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("leftover temp file %q after a successful Write", e.Name())
+		}
+	}
+}
+
+func TestFSBackendWriteFailureLeavesNoPartialBlob(t *testing.T) {
+	dir := t.TempDir()
+	b := NewFSBackend(dir)
+	key := PathKey{PathName: "ab", Filename: "abcdef"}
+
+	_, err := b.Write("peer1", key, io.MultiReader(strings.NewReader("partial"), errReader{}))
+	if err == nil {
+		t.Fatalf("Write should have failed")
+	}
+	if b.Has("peer1", key) {
+		t.Errorf("a failed Write should not leave a blob addressable at the final path")
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, io.ErrClosedPipe }
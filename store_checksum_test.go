@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T, backend StorageBackend) *Store {
+	t.Helper()
+	s, err := NewStore(StoreOpts{
+		Root:              t.TempDir(),
+		Backend:           backend,
+		PathTransformFunc: DefaultPathTransformFunc,
+	})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+func TestChecksumIsStableAndChangesWithContent(t *testing.T) {
+	s := newTestStore(t, NewMemoryBackend())
+	const id = "peer1"
+
+	if _, err := s.Write(id, "dir/a.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Write a.txt: %v", err)
+	}
+	if _, err := s.Write(id, "dir/sub/b.txt", strings.NewReader("world")); err != nil {
+		t.Fatalf("Write sub/b.txt: %v", err)
+	}
+
+	first, err := s.Checksum(id, "dir")
+	if err != nil {
+		t.Fatalf("Checksum: %v", err)
+	}
+	again, err := s.Checksum(id, "dir")
+	if err != nil {
+		t.Fatalf("Checksum (cached): %v", err)
+	}
+	if first != again {
+		t.Errorf("Checksum should be stable across calls: %q != %q", first, again)
+	}
+
+	if _, err := s.Write(id, "dir/a.txt", strings.NewReader("hello, again")); err != nil {
+		t.Fatalf("Write a.txt (overwrite): %v", err)
+	}
+	changed, err := s.Checksum(id, "dir")
+	if err != nil {
+		t.Fatalf("Checksum (after overwrite): %v", err)
+	}
+	if changed == first {
+		t.Errorf("Checksum should change after a child's content changes")
+	}
+}
+
+func TestChecksumWildcardMatchesLeavesInLexicalOrder(t *testing.T) {
+	s := newTestStore(t, NewMemoryBackend())
+	const id = "peer1"
+
+	for _, key := range []string{"logs/b.log", "logs/a.log", "logs/sub/c.log", "logs/readme.txt"} {
+		if _, err := s.Write(id, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Write %s: %v", key, err)
+		}
+	}
+
+	d1, err := s.ChecksumWildcard(id, "logs/**/*.log")
+	if err != nil {
+		t.Fatalf("ChecksumWildcard: %v", err)
+	}
+
+	// Order of writes shouldn't matter: a second store that sees the same
+	// matched set in a different write order must agree on the digest.
+	s2 := newTestStore(t, NewMemoryBackend())
+	for _, key := range []string{"logs/sub/c.log", "logs/a.log", "logs/readme.txt", "logs/b.log"} {
+		if _, err := s2.Write(id, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Write %s: %v", key, err)
+		}
+	}
+	d2, err := s2.ChecksumWildcard(id, "logs/**/*.log")
+	if err != nil {
+		t.Fatalf("ChecksumWildcard (s2): %v", err)
+	}
+
+	if d1 != d2 {
+		t.Errorf("ChecksumWildcard should agree regardless of write order: %q != %q", d1, d2)
+	}
+
+	if _, err := s.ChecksumWildcard(id, "logs/**/*.nomatch"); err == nil {
+		t.Errorf("ChecksumWildcard should error when the pattern matches nothing")
+	}
+}